@@ -0,0 +1,55 @@
+package logrotate
+
+import (
+	"io"
+	"io/fs"
+	"os"
+)
+
+// File is the subset of *os.File that an FS implementation's OpenFile must
+// return.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+	Stat() (fs.FileInfo, error)
+}
+
+// FS abstracts the filesystem operations Logger needs (OpenFile, Stat,
+// Rename, Remove, ReadDir, MkdirAll), so callers can plug in afero.Fs, an
+// in-memory FS for tests, or an S3-backed FS instead of the OS.
+type FS interface {
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	Stat(name string) (fs.FileInfo, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	ReadDir(dirname string) ([]fs.DirEntry, error)
+	MkdirAll(path string, perm fs.FileMode) error
+}
+
+// osFS is the default FS, backed directly by the os package.
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) ReadDir(dirname string) ([]fs.DirEntry, error) {
+	return os.ReadDir(dirname)
+}
+
+func (osFS) MkdirAll(path string, perm fs.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
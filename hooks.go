@@ -0,0 +1,18 @@
+package logrotate
+
+// Hooks holds optional callbacks a Logger invokes on rotation events, so
+// callers can wire rotation into metrics or logging systems instead of
+// polling the log directory. Any callback left nil is simply not called.
+type Hooks struct {
+	// OnRotate is called after the active file has been rotated to newPath.
+	// size is the size in bytes the active file had reached before rotation.
+	OnRotate func(oldPath, newPath string, size int64)
+	// OnCleanup is called after a retention pass removes backup files.
+	OnCleanup func(removed []string)
+	// OnError is called for errors that would otherwise be swallowed, such as
+	// a failed os.Remove or os.ReadDir during cleanup. stage identifies where
+	// the error occurred (e.g. "rotate", "open", "cleanup", "compress").
+	OnError func(stage string, err error)
+	// OnOpen is called after the active file is (re)opened for writing.
+	OnOpen func(path string)
+}
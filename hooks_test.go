@@ -0,0 +1,81 @@
+package logrotate
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHooksOnOpenAndOnRotateAreCalled(t *testing.T) {
+	fsys := newMemFS()
+
+	opened := make(chan string, 4)
+	rotated := make(chan string, 4)
+
+	logger := &Logger{
+		Filename: "app.log",
+		FS:       fsys,
+		Rule:     &fixedRotateRule{threshold: 4},
+		Hooks: Hooks{
+			OnOpen:   func(path string) { opened <- path },
+			OnRotate: func(oldPath, newPath string, size int64) { rotated <- newPath },
+		},
+	}
+	defer logger.Terminate()
+
+	if _, err := logger.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := logger.Write([]byte("world")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	select {
+	case path := <-opened:
+		if path != "app.log" {
+			t.Fatalf("OnOpen path = %q, want %q", path, "app.log")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("OnOpen was not called")
+	}
+
+	select {
+	case path := <-rotated:
+		if path != "app.log_backup" {
+			t.Fatalf("OnRotate newPath = %q, want %q", path, "app.log_backup")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("OnRotate was not called")
+	}
+}
+
+func TestDispatchHookDropsWhenQueueFull(t *testing.T) {
+	logger := &Logger{Filename: "app.log"}
+	defer logger.Terminate()
+
+	block := make(chan struct{})
+	var started sync.WaitGroup
+	started.Add(1)
+
+	logger.dispatchHook(func() {
+		started.Done()
+		<-block
+	})
+	started.Wait() // the hooks goroutine is now stuck in our callback, so the channel fills from here on.
+
+	for i := 0; i < 64; i++ {
+		logger.dispatchHook(func() {})
+	}
+
+	if before := logger.DroppedHooks(); before != 0 {
+		t.Fatalf("DroppedHooks() = %d before overflow, want 0", before)
+	}
+
+	logger.dispatchHook(func() {})
+
+	if got := logger.DroppedHooks(); got != 1 {
+		t.Fatalf("DroppedHooks() = %d after overflow, want 1", got)
+	}
+
+	close(block)
+}
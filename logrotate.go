@@ -2,17 +2,19 @@ package logrotate
 
 import (
 	"sync"
+	"sync/atomic"
 	"os"
-	"strings"
-	"sort"
+	"io"
 	"io/fs"
 	"errors"
 	"fmt"
 	"time"
 	"path/filepath"
+	"compress/gzip"
 )
 
 const TIME_FORMAT = "_20060102_150405.000"
+const COMPRESSED_SUFFIX = ".gz"
 const MEGABYTE = 1000 * 1000
 
 type Logger struct {
@@ -21,27 +23,72 @@ type Logger struct {
 	Size int64
 	MaxAge int
 	MaxBackups int
+	Compress bool
+	// CompressLevel is a gzip level (e.g. gzip.BestSpeed, gzip.NoCompression).
+	// Left nil, it defaults to gzip.DefaultCompression; a *int (rather than an
+	// int defaulted on zero) is needed because gzip.NoCompression is itself 0.
+	CompressLevel *int
+	Rule RotateRule
+	Async bool
+	QueueSize int
+	CloseTimeout time.Duration
+	FS FS
+	MultiProcess bool
+	Hooks Hooks
 
 	logMutex sync.Mutex
-	file *os.File
+	file File
 	fileSize int64
+	lock *processLock
 
 	cleanupOnce sync.Once
 	cleanupChannel chan bool
 	wg sync.WaitGroup
+
+	compressOnce sync.Once
+	compressChannel chan string
+	compressingMutex sync.Mutex
+	compressingFiles map[string]bool
+
+	asyncOnce sync.Once
+	asyncChannel chan []byte
+	asyncDone chan struct{}
+	errChannel chan error
+
+	hooksOnce sync.Once
+	hooksChannel chan func()
+	hooksDropped uint64
+
+	terminateOnce sync.Once
+	shutdownMutex sync.Mutex
+	terminated bool
+}
+
+var asyncBufferPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, 0, 4096)
+	},
 }
 
 func (this *Logger) Write(p []byte) (int, error) {
+	if this.Async {
+		return this.writeAsync(p)
+	}
+
 	this.logMutex.Lock()
 	defer this.logMutex.Unlock()
 
+	return this.writeSync(p)
+}
+
+func (this *Logger) writeSync(p []byte) (int, error) {
 	if this.file == nil {
 		if err := this.openFile(); err != nil {
 			return 0, err
 		}
 	}
 
-	if (this.fileSize > this.size()) {
+	if this.rule().ShallRotate(this.fileSize) {
 		if err := this.rotate(); err != nil {
 			return 0, err
 		}
@@ -53,33 +100,168 @@ func (this *Logger) Write(p []byte) (int, error) {
 	return n, err
 }
 
+// ErrTerminated is returned by Write in Async mode once Terminate (or Close)
+// has been called, since the async queue it would otherwise write to is gone.
+var ErrTerminated = errors.New("logrotate: Logger terminated")
+
+func (this *Logger) writeAsync(p []byte) (int, error) {
+	this.ensureAsync()
+
+	buf := asyncBufferPool.Get().([]byte)[:0]
+	buf = append(buf, p...)
+
+	this.shutdownMutex.Lock()
+	defer this.shutdownMutex.Unlock()
+
+	if this.terminated {
+		asyncBufferPool.Put(buf[:0])
+		return 0, ErrTerminated
+	}
+
+	// A non-blocking send, like cleanup/enqueueCompress/dispatchHook use: a
+	// blocking send held under shutdownMutex could deadlock against asyncLoop
+	// itself, since asyncLoop's writeSync can need this same mutex (via
+	// cleanup/enqueueCompress/dispatchHook) before it loops back to free up
+	// channel space.
+	select {
+	case this.asyncChannel <- buf:
+		return len(p), nil
+	default:
+		asyncBufferPool.Put(buf[:0])
+		err := fmt.Errorf("Async queue is full, dropping write of %d bytes", len(p))
+		this.pushErr(err)
+		return 0, err
+	}
+}
+
+// ErrChan returns the channel write errors from Async mode are surfaced on.
+func (this *Logger) ErrChan() <-chan error {
+	this.ensureAsync()
+
+	return this.errChannel
+}
+
+func (this *Logger) ensureAsync() {
+	this.asyncOnce.Do(func() {
+		this.asyncChannel = make(chan []byte, this.queueSize())
+		this.errChannel = make(chan error, this.queueSize())
+		this.asyncDone = make(chan struct{})
+		this.wg.Add(1)
+		go this.asyncLoop()
+	})
+}
+
+func (this *Logger) asyncLoop() {
+	defer this.wg.Done()
+	defer close(this.asyncDone)
+
+	for buf := range this.asyncChannel {
+		this.logMutex.Lock()
+		_, err := this.writeSync(buf)
+		this.logMutex.Unlock()
+
+		if err != nil {
+			this.pushErr(err)
+		}
+
+		asyncBufferPool.Put(buf[:0])
+	}
+}
+
+func (this *Logger) pushErr(err error) {
+	select {
+	case this.errChannel <- err:
+	default:
+	}
+}
+
+// drainAsync marks the Logger terminated and closes asyncChannel under
+// shutdownMutex, the same lock writeAsync checks before sending, so a
+// concurrent writeAsync either completes its send before this runs or sees
+// terminated and skips it - never a send on the now-closed channel.
+func (this *Logger) drainAsync() {
+	this.shutdownMutex.Lock()
+	this.terminated = true
+	hasAsync := this.asyncChannel != nil
+	if hasAsync {
+		close(this.asyncChannel)
+	}
+	this.shutdownMutex.Unlock()
+
+	if !hasAsync {
+		return
+	}
+
+	select {
+	case <-this.asyncDone:
+	case <-time.After(this.closeTimeout()):
+	}
+}
+
 func (this *Logger) Close() error {
+	this.Terminate()
+
 	this.logMutex.Lock()
 	defer this.logMutex.Unlock()
 
 	return this.closeFile()
 }
 
+// Terminate drains the Async queue and stops the cleanup/compress/hooks
+// workers. It is idempotent: calling it more than once (directly, or via
+// Close) is safe.
+//
+// drainAsync gives up waiting for asyncLoop after CloseTimeout, but asyncLoop
+// may still be running past that point (e.g. mid-rotation). Every channel is
+// only closed under shutdownMutex, and writeAsync/cleanup/enqueueCompress/
+// dispatchHook check the terminated flag under that same mutex before
+// sending, so a send and a close can never race: either the send completes
+// while the channel is still open, or it sees terminated and is skipped.
+// wg.Wait() still blocks until every worker, including a lingering asyncLoop,
+// actually exits, so CloseTimeout only bounds the drainAsync wait, not
+// Terminate as a whole.
 func (this *Logger) Terminate() {
-	this.logMutex.Lock()
-	defer this.logMutex.Unlock()
+	this.terminateOnce.Do(func() {
+		this.drainAsync()
 
-	if this.cleanupChannel != nil {
-		close(this.cleanupChannel)
-	}
-	this.wg.Wait()
+		this.shutdownMutex.Lock()
+		if this.cleanupChannel != nil {
+			close(this.cleanupChannel)
+		}
+		if this.compressChannel != nil {
+			close(this.compressChannel)
+		}
+		if this.hooksChannel != nil {
+			close(this.hooksChannel)
+		}
+		this.shutdownMutex.Unlock()
+
+		this.wg.Wait()
+	})
 }
 
 func (this *Logger) createFile() error {
-	f, err := os.OpenFile(this.filename(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, this.filemode())
+	path := this.filename()
+	f, err := this.filesystem().OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, this.filemode())
 	if err != nil {
-		return fmt.Errorf("Error while opening file in createFile: %s", err)
+		err = fmt.Errorf("Error while opening file in createFile: %s", err)
+		this.dispatchHook(func() { this.onError("open", err) })
+		return err
 	}
 	this.file = f
 	this.fileSize = 0
+	this.dispatchHook(func() { this.onOpen(path) })
 	return nil
 }
 
+// filesystem returns the Logger's FS, defaulting to the OS filesystem.
+func (this *Logger) filesystem() FS {
+	if this.FS != nil {
+		return this.FS
+	}
+	return osFS{}
+}
+
 func (this *Logger) closeFile() error {
 	if this.file == nil {
 		return nil
@@ -90,13 +272,15 @@ func (this *Logger) closeFile() error {
 }
 
 func (this *Logger) openFile() error {
-	_, err := os.Stat(this.filename())
+	_, err := this.filesystem().Stat(this.filename())
 	if errors.Is(err, fs.ErrNotExist) {
 		return this.openNewFile()
 	}
 
 	if err != nil {
-		return fmt.Errorf("Error in openFile: %s", err)
+		err = fmt.Errorf("Error in openFile: %s", err)
+		this.dispatchHook(func() { this.onError("open", err) })
+		return err
 	} else {
 		return this.openExistingFile()
 	}
@@ -105,13 +289,15 @@ func (this *Logger) openFile() error {
 }
 
 func (this *Logger) openNewFile() error {
-	err := os.MkdirAll(this.dir(), 0755)
+	err := this.filesystem().MkdirAll(this.dir(), 0755)
 	if err != nil {
-		return fmt.Errorf("Error while creating dir in openNewFile: %s", err)
+		err = fmt.Errorf("Error while creating dir in openNewFile: %s", err)
+		this.dispatchHook(func() { this.onError("open", err) })
+		return err
 	}
 
 	name := this.filename()
-	_, err = os.Stat(name)
+	_, err = this.filesystem().Stat(name)
 	if err == nil {
 		this.rotate()
 	} else {
@@ -123,29 +309,77 @@ func (this *Logger) openNewFile() error {
 
 func (this *Logger) openExistingFile() error {
 	name := this.filename()
-	f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, this.filemode())
+	f, err := this.filesystem().OpenFile(name, os.O_APPEND|os.O_WRONLY, this.filemode())
 	if err != nil {
 		return this.openNewFile()
 	}
 
-	info, err := os.Stat(name);
+	info, err := this.filesystem().Stat(name);
 	if err != nil {
-		return fmt.Errorf("Error in openExistingFile: %s", err)
+		err = fmt.Errorf("Error in openExistingFile: %s", err)
+		this.dispatchHook(func() { this.onError("open", err) })
+		return err
 	}
 	this.file = f
 	this.fileSize = info.Size()
+	this.dispatchHook(func() { this.onOpen(name) })
 	return nil
 }
 
+// rotate renames the active file to a backup name and opens a fresh active
+// file. When MultiProcess is set, the rename is serialized across processes
+// (e.g. sidecars sharing a log volume) via an advisory lock on a sibling
+// ".lock" file: after acquiring it, the active file is re-stat'd in case a
+// sibling process already rotated it, in which case this just reopens it
+// instead of renaming again. "Already rotated" is detected primarily by
+// comparing the inode of the fd we have open against whatever now sits at
+// name, since a sibling that rotated and then wrote past our last-known size
+// would be missed by a size-shrink check alone; the size-shrink check is
+// kept as a fallback for FS implementations whose os.SameFile comparison
+// can't identify the file (e.g. an in-memory FS).
 func (this *Logger) rotate() error {
-	timestamp := time.Now().Format(TIME_FORMAT)
-
 	name := this.filename()
-	newName := name + timestamp
+
+	if this.MultiProcess {
+		lock := this.processFileLock()
+		if err := lock.Lock(); err != nil {
+			err = fmt.Errorf("Error while acquiring lock in rotate: %s", err)
+			this.dispatchHook(func() { this.onError("rotate", err) })
+			return err
+		}
+		defer lock.Unlock()
+
+		pathInfo, statErr := this.filesystem().Stat(name)
+		if statErr == nil {
+			if this.file != nil {
+				if openInfo, err := this.file.Stat(); err == nil && os.SameFile(openInfo, openInfo) && !os.SameFile(openInfo, pathInfo) {
+					this.closeFile()
+					return this.openFile()
+				}
+			}
+
+			if pathInfo.Size() < this.fileSize {
+				this.closeFile()
+				return this.openFile()
+			}
+		}
+	}
+
+	oldSize := this.fileSize
+	newName := this.rule().BackupFileName(name)
 	this.closeFile()
 
-	if err := os.Rename(name, newName); err != nil {
-		return fmt.Errorf("Error while renaming file in rotate: %s", err)
+	if err := this.filesystem().Rename(name, newName); err != nil {
+		err = fmt.Errorf("Error while renaming file in rotate: %s", err)
+		this.dispatchHook(func() { this.onError("rotate", err) })
+		return err
+	}
+
+	this.rule().MarkRotated()
+	this.dispatchHook(func() { this.onRotate(name, newName, oldSize) })
+
+	if this.Compress {
+		this.enqueueCompress(newName)
 	}
 
 	this.cleanup()
@@ -153,100 +387,280 @@ func (this *Logger) rotate() error {
 	return this.createFile()
 }
 
+func (this *Logger) processFileLock() *processLock {
+	if this.lock == nil {
+		this.lock = &processLock{path: this.filename() + ".lock"}
+	}
+	return this.lock
+}
+
+// rule returns the Logger's RotateRule, defaulting to a SizeRotateRule built
+// from Size/MaxAge/MaxBackups when none was set.
+func (this *Logger) rule() RotateRule {
+	if this.Rule != nil {
+		return this.Rule
+	}
+	return &SizeRotateRule{Size: this.Size, MaxAge: this.MaxAge, MaxBackups: this.MaxBackups}
+}
+
 func (this *Logger) cleanup() {
-	this.cleanupOnce.Do(func() {
-		this.cleanupChannel = make(chan bool, 1)
-		go this.cleanupLoop()
-	})
+	this.shutdownMutex.Lock()
+	defer this.shutdownMutex.Unlock()
+	if this.terminated {
+		return
+	}
+	this.ensureCleanupWorker()
 
 	select {
 	case this.cleanupChannel <- true:
 	default:
 	}
+}
 
+func (this *Logger) ensureCleanupWorker() {
+	this.cleanupOnce.Do(func() {
+		this.cleanupChannel = make(chan bool, 16)
+		this.wg.Add(1)
+		go this.cleanupLoop()
+	})
 }
 
 func (this *Logger) cleanupLoop() {
-	this.wg.Add(1);
+	defer this.wg.Done()
 	for range this.cleanupChannel {
 		this.processCleanup()
 	}
-	this.wg.Done();
 }
 
-func (this *Logger) processCleanup() {
-	if this.MaxBackups == 0 && this.MaxAge == 0 {
-		return
-	}
-
-	cutoff := time.Now().Add(time.Duration(int64(-this.MaxAge * 24) * int64(time.Hour)))
+func (this *Logger) ensureHooksWorker() {
+	this.hooksOnce.Do(func() {
+		this.hooksChannel = make(chan func(), 64)
+		this.wg.Add(1)
+		go this.hooksLoop()
+	})
+}
 
-	if backupFiles, err := this.listBackupFiles(); err == nil {
-		keep := 0
-		for _, f := range backupFiles {
-			if keep >= this.MaxBackups {
-				os.Remove(filepath.Join(this.dir(), f.Name()))
-				continue
-			}
+func (this *Logger) hooksLoop() {
+	defer this.wg.Done()
+	for fn := range this.hooksChannel {
+		fn()
+	}
+}
 
-			if this.MaxAge > 0 {
-				if f.timestamp.Before(cutoff) {
-					os.Remove(filepath.Join(this.dir(), f.Name()))
-					continue
-				}
-			}
+// dispatchHook runs fn on a dedicated hooks goroutine instead of under
+// logMutex, so a slow Hooks callback can't stall Write. Dispatch is
+// non-blocking: if the hooks queue is full, fn is dropped and counted in
+// hooksDropped rather than blocking the caller (which, unlike cleanup's
+// coalesced retention signal, would stall whichever Write triggered it).
+func (this *Logger) dispatchHook(fn func()) {
+	this.shutdownMutex.Lock()
+	defer this.shutdownMutex.Unlock()
+	if this.terminated {
+		return
+	}
+	this.ensureHooksWorker()
 
-			keep++
-		}
+	select {
+	case this.hooksChannel <- fn:
+	default:
+		atomic.AddUint64(&this.hooksDropped, 1)
 	}
 }
 
-type backupFile struct {
-	timestamp time.Time
-	fs.DirEntry
+// DroppedHooks returns the number of hook callbacks dropped so far because
+// the hooks queue was full, e.g. from a stalled OnError handler.
+func (this *Logger) DroppedHooks() uint64 {
+	return atomic.LoadUint64(&this.hooksDropped)
 }
 
-type backupFiles []backupFile
+func (this *Logger) onRotate(oldPath, newPath string, size int64) {
+	if this.Hooks.OnRotate != nil {
+		this.Hooks.OnRotate(oldPath, newPath, size)
+	}
+}
 
-func (this backupFiles) Less(i, j int) bool {
-	return this[i].timestamp.After(this[j].timestamp)
+func (this *Logger) onCleanup(removed []string) {
+	if this.Hooks.OnCleanup != nil {
+		this.Hooks.OnCleanup(removed)
+	}
 }
 
-func (this backupFiles) Swap(i, j int) {
-	this[i], this[j] = this[j], this[i]
+func (this *Logger) onError(stage string, err error) {
+	if this.Hooks.OnError != nil {
+		this.Hooks.OnError(stage, err)
+	}
 }
 
-func (this backupFiles) Len() int {
-	return len(this)
+func (this *Logger) onOpen(path string) {
+	if this.Hooks.OnOpen != nil {
+		this.Hooks.OnOpen(path)
+	}
 }
 
-func (this *Logger) listBackupFiles() (backupFiles, error) {
-	files, _ := os.ReadDir(this.dir())
+func (this *Logger) processCleanup() {
+	dir := this.dir()
+	fsys := this.filesystem()
 
-	backupFiles := backupFiles{}
+	if _, err := fsys.ReadDir(dir); err != nil {
+		this.onError("cleanup", err)
+	}
 
-	for _, f := range files {
-		if f.IsDir() {
+	removed := []string{}
+	for _, name := range this.rule().OutdatedFiles(fsys, dir, this.filename()) {
+		path := filepath.Join(dir, name)
+		if this.isCompressing(path) {
 			continue
 		}
 
-		name := f.Name()
-		filename := this.filename()
-		if !strings.HasPrefix(name, filename) {
+		if err := fsys.Remove(path); err != nil {
+			this.onError("cleanup", err)
 			continue
 		}
 
-		t, err := time.Parse(TIME_FORMAT, strings.TrimPrefix(name, filename))
-		if err != nil {
-			continue
+		removed = append(removed, path)
+	}
+
+	if len(removed) > 0 {
+		this.onCleanup(removed)
+	}
+}
+
+// enqueueCompress sends path to compressLoop with a non-blocking select, like
+// cleanup/dispatchHook use: a blocking send held under shutdownMutex could
+// deadlock against compressLoop, since compressFile calls cleanup() at the
+// end of every item, which itself needs shutdownMutex before compressLoop
+// can loop back and free up channel space. dispatchHook is called, if
+// needed, after shutdownMutex is released, since it takes the same lock.
+func (this *Logger) enqueueCompress(path string) {
+	this.shutdownMutex.Lock()
+	terminated := this.terminated
+	sent := false
+	if !terminated {
+		this.compressOnce.Do(func() {
+			this.compressChannel = make(chan string, 16)
+			this.wg.Add(1)
+			go this.compressLoop()
+		})
+
+		select {
+		case this.compressChannel <- path:
+			sent = true
+		default:
 		}
+	}
+	this.shutdownMutex.Unlock()
+
+	if !terminated && !sent {
+		err := fmt.Errorf("Compress queue is full, dropping %s", path)
+		this.dispatchHook(func() { this.onError("compress", err) })
+	}
+}
+
+func (this *Logger) compressLoop() {
+	defer this.wg.Done()
+	for path := range this.compressChannel {
+		this.compressFile(path)
+	}
+}
+
+func (this *Logger) compressFile(path string) {
+	this.markCompressing(path, true)
+	defer this.markCompressing(path, false)
+
+	if err := this.doCompress(path); err != nil {
+		this.onError("compress", err)
+	}
+
+	this.cleanup()
+}
+
+func (this *Logger) doCompress(path string) error {
+	compressedName := path + COMPRESSED_SUFFIX
+	tmpName := compressedName + ".tmp"
+	fsys := this.filesystem()
+
+	src, err := fsys.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("Error while opening file in doCompress: %s", err)
+	}
+	defer src.Close()
+
+	dst, err := fsys.OpenFile(tmpName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, this.filemode())
+	if err != nil {
+		return fmt.Errorf("Error while creating temp file in doCompress: %s", err)
+	}
+
+	gzipWriter, err := gzip.NewWriterLevel(dst, this.compressLevel())
+	if err != nil {
+		dst.Close()
+		fsys.Remove(tmpName)
+		return fmt.Errorf("Error while creating gzip writer in doCompress: %s", err)
+	}
+
+	if _, err := io.Copy(gzipWriter, src); err != nil {
+		gzipWriter.Close()
+		dst.Close()
+		fsys.Remove(tmpName)
+		return fmt.Errorf("Error while compressing file in doCompress: %s", err)
+	}
+
+	if err := gzipWriter.Close(); err != nil {
+		dst.Close()
+		fsys.Remove(tmpName)
+		return fmt.Errorf("Error while closing gzip writer in doCompress: %s", err)
+	}
 
-		backupFiles = append(backupFiles, backupFile{t, f})
+	if err := dst.Close(); err != nil {
+		fsys.Remove(tmpName)
+		return fmt.Errorf("Error while closing temp file in doCompress: %s", err)
 	}
 
-	sort.Sort(backupFiles)
+	if err := fsys.Rename(tmpName, compressedName); err != nil {
+		return fmt.Errorf("Error while renaming temp file in doCompress: %s", err)
+	}
+
+	return fsys.Remove(path)
+}
+
+func (this *Logger) markCompressing(path string, compressing bool) {
+	this.compressingMutex.Lock()
+	defer this.compressingMutex.Unlock()
+
+	if this.compressingFiles == nil {
+		this.compressingFiles = map[string]bool{}
+	}
+
+	if compressing {
+		this.compressingFiles[path] = true
+	} else {
+		delete(this.compressingFiles, path)
+	}
+}
+
+func (this *Logger) isCompressing(path string) bool {
+	this.compressingMutex.Lock()
+	defer this.compressingMutex.Unlock()
+
+	return this.compressingFiles[path]
+}
+
+type backupFile struct {
+	timestamp time.Time
+	fs.DirEntry
+}
+
+type backupFiles []backupFile
+
+func (this backupFiles) Less(i, j int) bool {
+	return this[i].timestamp.After(this[j].timestamp)
+}
 
-	return backupFiles, nil
+func (this backupFiles) Swap(i, j int) {
+	this[i], this[j] = this[j], this[i]
+}
+
+func (this backupFiles) Len() int {
+	return len(this)
 }
 
 func (this *Logger) filename() string {
@@ -267,9 +681,23 @@ func (this *Logger) filemode() fs.FileMode {
 	return os.FileMode(0644)
 }
 
-func (this *Logger) size() int64 {
-	if this.Size != 0 {
-		return this.Size * MEGABYTE
+func (this *Logger) compressLevel() int {
+	if this.CompressLevel != nil {
+		return *this.CompressLevel
+	}
+	return gzip.DefaultCompression
+}
+
+func (this *Logger) queueSize() int {
+	if this.QueueSize != 0 {
+		return this.QueueSize
+	}
+	return 1024
+}
+
+func (this *Logger) closeTimeout() time.Duration {
+	if this.CloseTimeout != 0 {
+		return this.CloseTimeout
 	}
-	return 100 * MEGABYTE
+	return 5 * time.Second
 }
@@ -0,0 +1,284 @@
+package logrotate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memFS is a deterministic, in-memory FS implementation used to test
+// rotation, cleanup and compression without touching the real filesystem.
+type memFS struct {
+	mu       sync.Mutex
+	files    map[string][]byte
+	modTimes map[string]time.Time
+}
+
+func newMemFS() *memFS {
+	return &memFS{files: map[string][]byte{}, modTimes: map[string]time.Time{}}
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (this memFileInfo) Name() string       { return this.name }
+func (this memFileInfo) Size() int64        { return this.size }
+func (this memFileInfo) Mode() fs.FileMode  { return 0644 }
+func (this memFileInfo) ModTime() time.Time { return this.modTime }
+func (this memFileInfo) IsDir() bool        { return false }
+func (this memFileInfo) Sys() interface{}   { return nil }
+
+type memDirEntry struct {
+	info memFileInfo
+}
+
+func (this memDirEntry) Name() string               { return this.info.name }
+func (this memDirEntry) IsDir() bool                { return false }
+func (this memDirEntry) Type() fs.FileMode          { return 0 }
+func (this memDirEntry) Info() (fs.FileInfo, error) { return this.info, nil }
+
+type memFile struct {
+	fsys *memFS
+	name string
+	pos  int
+}
+
+func (this *memFile) Write(p []byte) (int, error) {
+	this.fsys.mu.Lock()
+	defer this.fsys.mu.Unlock()
+
+	this.fsys.files[this.name] = append(this.fsys.files[this.name], p...)
+	this.fsys.modTimes[this.name] = time.Now()
+	return len(p), nil
+}
+
+func (this *memFile) Read(p []byte) (int, error) {
+	this.fsys.mu.Lock()
+	data := this.fsys.files[this.name]
+	this.fsys.mu.Unlock()
+
+	if this.pos >= len(data) {
+		return 0, io.EOF
+	}
+	n := copy(p, data[this.pos:])
+	this.pos += n
+	return n, nil
+}
+
+func (this *memFile) Close() error { return nil }
+
+func (this *memFile) Stat() (fs.FileInfo, error) {
+	return this.fsys.Stat(this.name)
+}
+
+func (this *memFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	this.mu.Lock()
+	_, exists := this.files[name]
+	if flag&os.O_CREATE != 0 && !exists {
+		this.files[name] = []byte{}
+		this.modTimes[name] = time.Now()
+		exists = true
+	}
+	if flag&os.O_TRUNC != 0 {
+		this.files[name] = []byte{}
+	}
+	this.mu.Unlock()
+
+	if !exists {
+		return nil, fs.ErrNotExist
+	}
+
+	return &memFile{fsys: this, name: name}, nil
+}
+
+func (this *memFS) Stat(name string) (fs.FileInfo, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	data, ok := this.files[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	return memFileInfo{name: filepath.Base(name), size: int64(len(data)), modTime: this.modTimes[name]}, nil
+}
+
+func (this *memFS) Rename(oldpath, newpath string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	data, ok := this.files[oldpath]
+	if !ok {
+		return fs.ErrNotExist
+	}
+
+	this.files[newpath] = data
+	this.modTimes[newpath] = this.modTimes[oldpath]
+	delete(this.files, oldpath)
+	delete(this.modTimes, oldpath)
+	return nil
+}
+
+func (this *memFS) Remove(name string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if _, ok := this.files[name]; !ok {
+		return fs.ErrNotExist
+	}
+
+	delete(this.files, name)
+	delete(this.modTimes, name)
+	return nil
+}
+
+func (this *memFS) ReadDir(dirname string) ([]fs.DirEntry, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	entries := []fs.DirEntry{}
+	for name, data := range this.files {
+		if filepath.Dir(name) != dirname {
+			continue
+		}
+
+		entries = append(entries, memDirEntry{info: memFileInfo{name: filepath.Base(name), size: int64(len(data)), modTime: this.modTimes[name]}})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	return entries, nil
+}
+
+func (this *memFS) MkdirAll(path string, perm fs.FileMode) error {
+	return nil
+}
+
+// fixedRotateRule rotates once fileSize exceeds threshold and names every
+// backup the same, so tests don't need to write megabytes of data just to
+// cross SizeRotateRule's MB-denominated Size field.
+type fixedRotateRule struct {
+	threshold int64
+	backups   []string
+}
+
+func (this *fixedRotateRule) ShallRotate(fileSize int64) bool   { return fileSize > this.threshold }
+func (this *fixedRotateRule) BackupFileName(base string) string { return base + "_backup" }
+func (this *fixedRotateRule) MarkRotated()                      {}
+func (this *fixedRotateRule) OutdatedFiles(fsys FS, dir, base string) []string {
+	return this.backups
+}
+
+func TestWriteRotatesWhenRuleSaysSo(t *testing.T) {
+	fsys := newMemFS()
+	logger := &Logger{Filename: "app.log", FS: fsys, Rule: &fixedRotateRule{threshold: 4}}
+	defer logger.Terminate()
+
+	if _, err := logger.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := logger.Write([]byte("world")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	logger.Terminate()
+
+	if got, ok := fsys.files["app.log_backup"]; !ok || string(got) != "hello" {
+		t.Fatalf("expected app.log_backup to contain %q, got %q (exists=%v)", "hello", got, ok)
+	}
+	if got := string(fsys.files["app.log"]); got != "world" {
+		t.Fatalf("expected active file to contain only the post-rotation write, got %q", got)
+	}
+}
+
+func TestProcessCleanupRemovesOutdatedBackups(t *testing.T) {
+	fsys := newMemFS()
+	fsys.files["app.log"] = []byte("active")
+	fsys.files["old1"] = []byte("x")
+	fsys.files["old2"] = []byte("y")
+
+	logger := &Logger{Filename: "app.log", FS: fsys, Rule: &fixedRotateRule{backups: []string{"old1", "old2"}}}
+
+	logger.cleanup()
+	logger.Terminate()
+
+	if _, ok := fsys.files["old1"]; ok {
+		t.Fatalf("expected old1 to be removed")
+	}
+	if _, ok := fsys.files["old2"]; ok {
+		t.Fatalf("expected old2 to be removed")
+	}
+	if _, ok := fsys.files["app.log"]; !ok {
+		t.Fatalf("expected active file to survive cleanup")
+	}
+}
+
+func TestCompressProducesGzipBackupAndRemovesSource(t *testing.T) {
+	fsys := newMemFS()
+	fsys.files["app.log_20230101"] = []byte("payload")
+
+	logger := &Logger{Filename: "app.log", FS: fsys, Compress: true}
+	logger.enqueueCompress("app.log_20230101")
+	logger.Terminate()
+
+	data, ok := fsys.files["app.log_20230101.gz"]
+	if !ok {
+		t.Fatalf("expected compressed backup app.log_20230101.gz to exist")
+	}
+
+	gzReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	defer gzReader.Close()
+
+	decompressed, err := io.ReadAll(gzReader)
+	if err != nil {
+		t.Fatalf("reading gzip payload: %v", err)
+	}
+	if string(decompressed) != "payload" {
+		t.Fatalf("got %q, want %q", decompressed, "payload")
+	}
+
+	if _, ok := fsys.files["app.log_20230101"]; ok {
+		t.Fatalf("expected source file to be removed after compression")
+	}
+}
+
+// TestAsyncTerminateDoesNotPanic reproduces the scenario from the review: a
+// tiny CloseTimeout combined with concurrent Async writes that keep
+// triggering rotation, so Terminate races a still-running asyncLoop. Run
+// with -race to confirm there's no data race around the shutdown flag either.
+func TestAsyncTerminateDoesNotPanic(t *testing.T) {
+	fsys := newMemFS()
+	logger := &Logger{
+		Filename:     "app.log",
+		FS:           fsys,
+		Async:        true,
+		CloseTimeout: time.Nanosecond,
+		Rule:         &fixedRotateRule{threshold: 8},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			logger.Write([]byte(fmt.Sprintf("line-%d\n", i)))
+		}(i)
+	}
+	wg.Wait()
+
+	logger.Terminate()
+}
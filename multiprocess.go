@@ -0,0 +1,41 @@
+package logrotate
+
+import (
+	"os"
+)
+
+// processLock is an OS-level advisory lock on a sibling ".lock" file, used to
+// serialize rotation across processes that share the same log file. It is
+// deliberately independent of the FS abstraction: the locking primitive
+// (flock on unix, LockFileEx on windows, implemented in the platform-specific
+// filelock_*.go files) only makes sense against a real local file.
+type processLock struct {
+	path string
+	file *os.File
+}
+
+func (this *processLock) Lock() error {
+	f, err := os.OpenFile(this.path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	this.file = f
+	return nil
+}
+
+func (this *processLock) Unlock() error {
+	if this.file == nil {
+		return nil
+	}
+
+	err := unlockFile(this.file)
+	this.file.Close()
+	this.file = nil
+	return err
+}
@@ -0,0 +1,80 @@
+package logrotate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProcessLockSerializesAcrossInstances(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rotate.lock")
+
+	first := &processLock{path: path}
+	if err := first.Lock(); err != nil {
+		t.Fatalf("first.Lock(): %v", err)
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		second := &processLock{path: path}
+		if err := second.Lock(); err != nil {
+			t.Errorf("second.Lock(): %v", err)
+			return
+		}
+		defer second.Unlock()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatalf("second.Lock() succeeded while first still held the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := first.Unlock(); err != nil {
+		t.Fatalf("first.Unlock(): %v", err)
+	}
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("second.Lock() never succeeded after first.Unlock()")
+	}
+}
+
+func TestRotateMultiProcessDetectsSiblingRotationByInode(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "app.log")
+
+	logger := &Logger{Filename: filename, MultiProcess: true, Rule: &fixedRotateRule{threshold: 1 << 30}}
+	defer logger.Terminate()
+
+	if _, err := logger.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	// Simulate a sibling process having already rotated the file: rename it
+	// away and create a fresh one in its place, with a different inode.
+	if err := os.Rename(filename, filename+"_sibling_backup"); err != nil {
+		t.Fatalf("os.Rename: %v", err)
+	}
+	if err := os.WriteFile(filename, []byte("new-from-sibling"), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+
+	if err := logger.rotate(); err != nil {
+		t.Fatalf("rotate(): %v", err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+	if string(data) != "new-from-sibling" {
+		t.Fatalf("rotate() clobbered the sibling's file: got %q, want %q", data, "new-from-sibling")
+	}
+	if logger.fileSize != int64(len("new-from-sibling")) {
+		t.Fatalf("fileSize = %d, want %d after reopening the sibling's file", logger.fileSize, len("new-from-sibling"))
+	}
+}
@@ -0,0 +1,68 @@
+package logrotate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewDailyRotateRuleDefaults(t *testing.T) {
+	rule := NewDailyRotateRule(5, 7)
+
+	if rule.Interval != 24*time.Hour {
+		t.Fatalf("Interval = %v, want 24h", rule.Interval)
+	}
+	if rule.Delimiter != "-2006-01-02" {
+		t.Fatalf("Delimiter = %q, want %q", rule.Delimiter, "-2006-01-02")
+	}
+	if rule.MaxBackups != 5 || rule.MaxAge != 7 {
+		t.Fatalf("MaxBackups/MaxAge = %d/%d, want 5/7", rule.MaxBackups, rule.MaxAge)
+	}
+}
+
+func TestNewHourlyRotateRuleDefaults(t *testing.T) {
+	rule := NewHourlyRotateRule(3, 1)
+
+	if rule.Interval != time.Hour {
+		t.Fatalf("Interval = %v, want 1h", rule.Interval)
+	}
+	if rule.Delimiter != "-2006-01-02-15" {
+		t.Fatalf("Delimiter = %q, want %q", rule.Delimiter, "-2006-01-02-15")
+	}
+}
+
+func TestTimeRotateRuleDelimiterDerivesFromSubDayInterval(t *testing.T) {
+	rule := &TimeRotateRule{Interval: 50 * time.Millisecond}
+
+	got := rule.delimiter()
+	want := "-2006-01-02-15-04-05.000"
+	if got != want {
+		t.Fatalf("delimiter() = %q, want %q for a sub-day Interval", got, want)
+	}
+}
+
+func TestTimeRotateRuleDelimiterDefaultsToDayForDayOrLongerInterval(t *testing.T) {
+	rule := &TimeRotateRule{}
+
+	if got, want := rule.delimiter(), "-2006-01-02"; got != want {
+		t.Fatalf("delimiter() = %q, want %q for the default (24h) Interval", got, want)
+	}
+
+	rule = &TimeRotateRule{Interval: 48 * time.Hour}
+	if got, want := rule.delimiter(), "-2006-01-02"; got != want {
+		t.Fatalf("delimiter() = %q, want %q for a 48h Interval", got, want)
+	}
+}
+
+func TestTimeRotateRuleShallRotate(t *testing.T) {
+	rule := &TimeRotateRule{Interval: 10 * time.Millisecond}
+
+	if rule.ShallRotate(0) {
+		t.Fatalf("ShallRotate() = true on first call, want false (it only establishes the baseline)")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if !rule.ShallRotate(0) {
+		t.Fatalf("ShallRotate() = false after Interval elapsed, want true")
+	}
+}